@@ -0,0 +1,11 @@
+package cmd
+
+// StorageURL holds the --storage backend URL (eg "file:///var/lib/dio", "s3://bucket/prefix",
+// "gs://bucket/prefix"), as set on the root command.  It's read by a cobra.OnInitialize hook at
+// startup to construct the configured Storage backend.
+var StorageURL string
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&StorageURL, "storage", "",
+		"URL of the blob storage backend to use (file://, s3://, or gs://).  Defaults to local storage.")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/justinclift/dio/cmd"
+	rq "github.com/parnurzeal/gorequest"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var fsckCmdRepair bool
+
+// Checks the integrity of locally stored dio objects.
+var fsckCmd = &cobra.Command{
+	Use:   "fsck [database name]",
+	Short: "Check the integrity of locally stored dio objects",
+	Long: "Checks the integrity of locally stored dio objects.  If no database name is given, " +
+		"every database dio has local metadata for is checked.",
+	RunE: func(c *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("Only one database can be checked at a time (for now)")
+		}
+
+		names := args
+		if len(names) == 0 {
+			all, err := listDatabases()
+			if err != nil {
+				return err
+			}
+			names = all
+		}
+
+		problems := 0
+		for _, dbName := range names {
+			n, err := runFsck(dbName, fsckCmdRepair)
+			if err != nil {
+				return err
+			}
+			problems += n
+		}
+
+		dangling, err := findDanglingObjects()
+		if err != nil {
+			return err
+		}
+		for _, d := range dangling {
+			fmt.Printf("dangling object: %s\n", d)
+		}
+		problems += len(dangling)
+
+		if problems == 0 {
+			fmt.Println("no problems found")
+			return nil
+		}
+		return fmt.Errorf("%d problem(s) found", problems)
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&fsckCmdRepair, "repair", false,
+		"Re-download corrupted or missing objects from the configured remote, if possible")
+}
+
+// runFsck walks every commit recorded for dbName, re-derives its commit ID via createCommitID,
+// re-derives its tree ID via createDBTreeID, and re-hashes every database blob the tree points
+// at, printing any mismatched or missing objects it finds.  It returns the number of problems
+// found.
+func runFsck(dbName string, repair bool) (int, error) {
+	index, err := getIndex(dbName)
+	if err != nil {
+		return 0, err
+	}
+
+	problems := 0
+	for _, c := range index {
+		if wantID := createCommitID(c); wantID != c.ID {
+			fmt.Printf("%s: commit %s: recomputed ID %s doesn't match\n", dbName, c.ID, wantID)
+			problems++
+		}
+
+		t, err := readTree(c.Tree, repair)
+		if err != nil {
+			fmt.Printf("%s: commit %s: tree %s is missing or corrupt: %v\n", dbName, c.ID, c.Tree, err)
+			problems++
+			continue
+		}
+		if wantTree := createDBTreeID(t.Entries); wantTree != c.Tree {
+			fmt.Printf("%s: commit %s: recomputed tree ID %s doesn't match %s\n", dbName, c.ID, wantTree, c.Tree)
+			problems++
+		}
+
+		for _, e := range t.Entries {
+			if err := checkBlob(e.ShaSum, repair); err != nil {
+				fmt.Printf("%s: commit %s: %v\n", dbName, c.ID, err)
+				problems++
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// findDanglingObjects scans every object under files/ (excluding files/packs/, whose delta packs
+// are referenced indirectly via deltas.json rather than from a tree) and reports any whose name
+// isn't reachable as a commit ID, tree ID, or blob sha from any commit in any locally known
+// database.
+func findDanglingObjects() ([]string, error) {
+	names, err := listDatabases()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[string]bool)
+	for _, dbName := range names {
+		index, err := getIndex(dbName)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range index {
+			reachable[c.ID] = true
+			reachable[c.Tree] = true
+
+			j, err := store.Read(filepath.Join("files", c.Tree))
+			if err != nil {
+				// Already reported by runFsck; nothing more to do with it here.
+				continue
+			}
+			var t dbTree
+			if err := json.Unmarshal(j, &t); err != nil {
+				continue
+			}
+			for _, e := range t.Entries {
+				reachable[e.ShaSum] = true
+			}
+		}
+	}
+
+	all, err := store.List("files")
+	if err != nil {
+		return nil, err
+	}
+
+	var dangling []string
+	for _, p := range all {
+		if filepath.Dir(p) != "files" {
+			continue
+		}
+		if name := filepath.Base(p); !reachable[name] {
+			dangling = append(dangling, name)
+		}
+	}
+	return dangling, nil
+}
+
+// readTree reads and parses the tree object identified by treeID, repairing it from cloud first
+// if repair is set and it's currently missing or corrupt.
+func readTree(treeID string, repair bool) (dbTree, error) {
+	p := filepath.Join("files", treeID)
+	j, err := store.Read(p)
+	if err != nil {
+		if !repair {
+			return dbTree{}, err
+		}
+		if rErr := repairObject(p); rErr != nil {
+			return dbTree{}, fmt.Errorf("%v, and repair failed: %v", err, rErr)
+		}
+		if j, err = store.Read(p); err != nil {
+			return dbTree{}, err
+		}
+	}
+
+	var t dbTree
+	if err := json.Unmarshal(j, &t); err != nil {
+		return dbTree{}, fmt.Errorf("doesn't parse as a tree: %v", err)
+	}
+	return t, nil
+}
+
+// checkBlob verifies a single content-addressed database blob exists and is correctly hashed,
+// optionally repairing it by re-downloading from cloud.  A blob stored as a page-level delta
+// (see pack.go) never has a files/<sha> entry of its own, so such blobs are checked by
+// reconstructing them through loadDatabase instead of reading files/<sha> directly.
+func checkBlob(sha string, repair bool) error {
+	rec, err := getDeltaRecord(sha)
+	if err != nil {
+		return err
+	}
+
+	if rec == nil || rec.Kind == "full" {
+		p := filepath.Join("files", sha)
+		if _, err := readVerifiedBlob(p, sha); err == nil {
+			return nil
+		} else if !repair {
+			return fmt.Errorf("blob %s is missing or corrupt: %v", sha, err)
+		} else if rErr := repairObject(p); rErr != nil {
+			return fmt.Errorf("blob %s is missing or corrupt, and repair failed: %v", sha, rErr)
+		}
+		return nil
+	}
+
+	db, loadErr := loadDatabase(sha)
+	if loadErr == nil {
+		sum := sha256.Sum256(db)
+		if hex.EncodeToString(sum[:]) == sha {
+			return nil
+		}
+		loadErr = fmt.Errorf("reconstructed delta content doesn't match sha256 %s", sha)
+	}
+	if !repair {
+		return fmt.Errorf("blob %s is missing or corrupt: %v", sha, loadErr)
+	}
+	if rErr := repairObject(filepath.Join("files", "packs", sha)); rErr != nil {
+		return fmt.Errorf("blob %s is missing or corrupt, and repair failed: %v", sha, rErr)
+	}
+	return nil
+}
+
+// repairObject re-downloads path from the configured remote cloud and stores it locally.
+func repairObject(path string) error {
+	resp, body, errs := rq.New().Get(cloud+"/object").
+		Set("path", path).
+		End()
+	if errs != nil {
+		return fmt.Errorf("error downloading object: %v", errs[0])
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("object download failed with HTTP status %d - '%v'", resp.StatusCode, resp.Status)
+	}
+	return store.Write(path, []byte(body))
+}
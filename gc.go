@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/justinclift/dio/cmd"
+	"github.com/spf13/cobra"
+)
+
+var gcCmdRepack bool
+var gcCmdMaxChainDepth int
+
+// Runs housekeeping on local dio storage.
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Run housekeeping on local dio storage",
+	RunE: func(c *cobra.Command, args []string) error {
+		if !gcCmdRepack {
+			return nil
+		}
+		n, err := repackDeltaChains(gcCmdMaxChainDepth)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Repacked %d database blob(s) with delta chains longer than %d\n", n, gcCmdMaxChainDepth)
+		return nil
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVar(&gcCmdRepack, "repack", false,
+		"Convert long delta chains back into full blobs")
+	gcCmd.Flags().IntVar(&gcCmdMaxChainDepth, "max-chain-depth", 10,
+		"Maximum delta chain length allowed before a blob is repacked")
+}
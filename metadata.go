@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// metaDB is the lazily-opened SQLite connection backing the databases/branches/commits/
+// tree_entries tables.  It replaces the old meta/<db>/index and meta/<db>/branches JSON files.
+var metaDB *sql.DB
+
+const metadataSchema = `
+CREATE TABLE IF NOT EXISTS databases (
+	name TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS branches (
+	database  TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	commit_id TEXT NOT NULL,
+	PRIMARY KEY (database, name)
+);
+CREATE TABLE IF NOT EXISTS commits (
+	id              TEXT PRIMARY KEY,
+	database        TEXT NOT NULL,
+	parent          TEXT,
+	tree            TEXT NOT NULL,
+	author_name     TEXT,
+	author_email    TEXT,
+	committer_name  TEXT,
+	committer_email TEXT,
+	message         TEXT,
+	timestamp       DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tree_entries (
+	tree_id TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	type    TEXT NOT NULL,
+	sha     TEXT NOT NULL,
+	PRIMARY KEY (tree_id, name)
+);
+`
+
+// openMetadataDB opens (creating and migrating if necessary) the dio.db SQLite database under
+// STORAGEDIR, caching the connection for reuse.
+func openMetadataDB() (*sql.DB, error) {
+	if metaDB != nil {
+		return metaDB, nil
+	}
+
+	p := filepath.Join(STORAGEDIR, "dio.db")
+	_, err := os.Stat(p)
+	firstRun := os.IsNotExist(err)
+
+	db, err := sql.Open("sqlite3", p)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open metadata database: %v", err)
+	}
+	if _, err := db.Exec(metadataSchema); err != nil {
+		return nil, fmt.Errorf("couldn't create metadata schema: %v", err)
+	}
+	metaDB = db
+
+	if firstRun {
+		if err := migrateJSONMetadata(db); err != nil {
+			return nil, fmt.Errorf("couldn't migrate existing JSON metadata: %v", err)
+		}
+	}
+	return metaDB, nil
+}
+
+// migrateJSONMetadata does a one-time import of any legacy meta/<db>/index and
+// meta/<db>/branches JSON files into the SQLite-backed schema.  It's run automatically the
+// first time dio.db is created.
+func migrateJSONMetadata(db *sql.DB) error {
+	metaDir := filepath.Join(STORAGEDIR, "meta")
+	entries, err := ioutil.ReadDir(metaDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("couldn't read legacy meta directory: %v", err)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dbName := e.Name()
+
+		if b, err := ioutil.ReadFile(filepath.Join(metaDir, dbName, "index")); err == nil {
+			var idx []commit
+			if err := json.Unmarshal(b, &idx); err != nil {
+				return fmt.Errorf("couldn't parse legacy index for '%s': %v", dbName, err)
+			}
+			if err := storeIndex(dbName, idx); err != nil {
+				return err
+			}
+		}
+
+		if b, err := ioutil.ReadFile(filepath.Join(metaDir, dbName, "branches")); err == nil {
+			var branches []branch
+			if err := json.Unmarshal(b, &branches); err != nil {
+				return fmt.Errorf("couldn't parse legacy branches for '%s': %v", dbName, err)
+			}
+			if err := storeBranches(dbName, branches); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registerDatabase records dbPath in the databases table, if it's not already there.
+func registerDatabase(tx *sql.Tx, dbPath string) error {
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO databases (name) VALUES (?)`, dbPath); err != nil {
+		return fmt.Errorf("couldn't record database '%s': %v", dbPath, err)
+	}
+	return nil
+}
+
+// listDatabases returns the name of every database dio has stored metadata for.
+func listDatabases() ([]string, error) {
+	db, err := openMetadataDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT name FROM databases`)
+	if err != nil {
+		log.Printf("Something went wrong when querying the databases table: %v\n", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			log.Printf("Something went wrong when reading a database row: %v\n", err.Error())
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	return names, rows.Err()
+}
+
+// getIndex returns the commit history recorded for database d, ordered oldest first.
+func getIndex(d string) ([]commit, error) {
+	db, err := openMetadataDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, parent, tree, author_name, author_email, committer_name,
+		committer_email, message, timestamp FROM commits WHERE database = ? ORDER BY timestamp`, d)
+	if err != nil {
+		log.Printf("Something went wrong when querying the commits for '%s': %v\n", d, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var i []commit
+	for rows.Next() {
+		var c commit
+		if err := rows.Scan(&c.ID, &c.Parent, &c.Tree, &c.AuthorName, &c.AuthorEmail, &c.CommitterName,
+			&c.CommitterEmail, &c.Message, &c.Timestamp); err != nil {
+			log.Printf("Something went wrong when reading a commit row for '%s': %v\n", d, err.Error())
+			return nil, err
+		}
+		i = append(i, c)
+	}
+	return i, rows.Err()
+}
+
+// storeIndex replaces the commit history recorded for database dbPath with index.
+func storeIndex(dbPath string, index []commit) error {
+	db, err := openMetadataDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("couldn't start metadata transaction: %v", err)
+	}
+	if err := registerDatabase(tx, dbPath); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM commits WHERE database = ?`, dbPath); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't clear existing commits for '%s': %v", dbPath, err)
+	}
+	for _, c := range index {
+		_, err := tx.Exec(`INSERT INTO commits
+			(id, database, parent, tree, author_name, author_email, committer_name, committer_email, message, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, dbPath, c.Parent, c.Tree, c.AuthorName, c.AuthorEmail, c.CommitterName, c.CommitterEmail,
+			c.Message, c.Timestamp)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("couldn't insert commit '%s': %v", c.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("couldn't commit metadata transaction: %v", err)
+	}
+	return nil
+}
+
+// storeTreeEntries replaces the tree_entries rows recorded for t.ID with t's current entries.
+func storeTreeEntries(t dbTree) error {
+	db, err := openMetadataDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("couldn't start metadata transaction: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM tree_entries WHERE tree_id = ?`, t.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't clear existing tree entries for '%s': %v", t.ID, err)
+	}
+	for _, e := range t.Entries {
+		if _, err := tx.Exec(`INSERT INTO tree_entries (tree_id, name, type, sha) VALUES (?, ?, ?, ?)`,
+			t.ID, e.Name, string(e.AType), e.ShaSum); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("couldn't insert tree entry '%s': %v", e.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("couldn't commit metadata transaction: %v", err)
+	}
+	return nil
+}
+
+// getBranches returns the branches recorded for database d.
+func getBranches(d string) ([]branch, error) {
+	db, err := openMetadataDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT name, commit_id FROM branches WHERE database = ?`, d)
+	if err != nil {
+		log.Printf("Something went wrong when querying the branches for '%s': %v\n", d, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var branches []branch
+	for rows.Next() {
+		var b branch
+		if err := rows.Scan(&b.Name, &b.Commit); err != nil {
+			log.Printf("Something went wrong when reading a branch row for '%s': %v\n", d, err.Error())
+			return nil, err
+		}
+		branches = append(branches, b)
+	}
+	return branches, rows.Err()
+}
+
+// storeBranches replaces the set of branches recorded for database dbPath with branches.
+func storeBranches(dbPath string, branches []branch) error {
+	db, err := openMetadataDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("couldn't start metadata transaction: %v", err)
+	}
+	if err := registerDatabase(tx, dbPath); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM branches WHERE database = ?`, dbPath); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("couldn't clear existing branches for '%s': %v", dbPath, err)
+	}
+	for _, b := range branches {
+		if _, err := tx.Exec(`INSERT INTO branches (database, name, commit_id) VALUES (?, ?, ?)`,
+			dbPath, b.Name, b.Commit); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("couldn't insert branch '%s': %v", b.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("couldn't commit metadata transaction: %v", err)
+	}
+	return nil
+}
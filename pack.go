@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// sqliteHeaderSize is the length (in bytes) of the fixed SQLite database file header.
+const sqliteHeaderSize = 100
+
+// deltaEntry records the sha256 of a single page of a database, at a given page index.
+type deltaEntry struct {
+	PageIndex int    `json:"pageIndex"`
+	Sha256    string `json:"sha256"`
+}
+
+// pack is the on-disk representation of a page-level delta between a database blob and its
+// parent commit's database blob.
+type pack struct {
+	ParentSha    string       `json:"parentSha"`
+	PageSize     int          `json:"pageSize"`
+	Pages        []deltaEntry `json:"pages"`        // every page, in position order
+	ChangedPages [][]byte     `json:"changedPages"` // raw bytes of just the changed pages, in page order
+}
+
+// deltaRecord is the `deltas.json` entry for a single stored database blob: either a full blob
+// (Kind == "full"), or a delta against Parent (Kind == "delta").
+type deltaRecord struct {
+	Kind   string `json:"kind"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// sqlitePageSize reads the page size from a SQLite database file's header (bytes 16-17, big
+// endian), per the SQLite file format.  A stored value of 1 means a 64KB page size.
+func sqlitePageSize(db []byte) (int, error) {
+	if len(db) < sqliteHeaderSize {
+		return 0, fmt.Errorf("file is too small to be a SQLite database")
+	}
+	n := int(binary.BigEndian.Uint16(db[16:18]))
+	if n == 1 {
+		return 65536, nil
+	}
+	return n, nil
+}
+
+// splitPages splits db into pageSize-sized pages.  The final page may be shorter than pageSize
+// if db's length isn't an exact multiple.
+func splitPages(db []byte, pageSize int) [][]byte {
+	var pages [][]byte
+	for i := 0; i < len(db); i += pageSize {
+		end := i + pageSize
+		if end > len(db) {
+			end = len(db)
+		}
+		pages = append(pages, db[i:end])
+	}
+	return pages
+}
+
+// buildDelta computes a page-level delta of child against parent, recording the sha256 of every
+// page but storing the raw bytes of only the pages that changed.
+func buildDelta(parent, child []byte) (*pack, error) {
+	pageSize, err := sqlitePageSize(child)
+	if err != nil {
+		return nil, err
+	}
+	parentPages := splitPages(parent, pageSize)
+	childPages := splitPages(child, pageSize)
+
+	p := &pack{PageSize: pageSize}
+	for i, page := range childPages {
+		sum := sha256.Sum256(page)
+		p.Pages = append(p.Pages, deltaEntry{PageIndex: i, Sha256: hex.EncodeToString(sum[:])})
+		if i < len(parentPages) && bytes.Equal(parentPages[i], page) {
+			continue
+		}
+		p.ChangedPages = append(p.ChangedPages, page)
+	}
+	return p, nil
+}
+
+// applyDelta reconstructs a database blob by overlaying p's changed pages onto parent.
+func applyDelta(parent []byte, p *pack) ([]byte, error) {
+	parentPages := splitPages(parent, p.PageSize)
+
+	var out bytes.Buffer
+	changedIdx := 0
+	for i, entry := range p.Pages {
+		if i < len(parentPages) {
+			sum := sha256.Sum256(parentPages[i])
+			if hex.EncodeToString(sum[:]) == entry.Sha256 {
+				out.Write(parentPages[i])
+				continue
+			}
+		}
+		if changedIdx >= len(p.ChangedPages) {
+			return nil, fmt.Errorf("corrupt pack: missing changed page data for page %d", entry.PageIndex)
+		}
+		out.Write(p.ChangedPages[changedIdx])
+		changedIdx++
+	}
+	return out.Bytes(), nil
+}
+
+// storeDatabaseWithParent stores db as a page-level delta against parentSha when possible,
+// falling back to a full blob when parentSha is empty (the first commit on a branch) or a delta
+// can't be computed.
+func storeDatabaseWithParent(db []byte, parentSha string) (string, error) {
+	s := sha256.Sum256(db)
+	t := hex.EncodeToString(s[:])
+
+	if parentSha == "" || t == parentSha {
+		// Either there's nothing to delta against, or db is byte-identical to its parent (eg
+		// pulling a commit that didn't touch the database, or re-pulling with nothing new
+		// upstream) -- store it as a full blob rather than emitting a delta record whose parent
+		// is its own sha, which would make loadDatabase recurse into itself forever.
+		return storeDatabase(db)
+	}
+
+	if rec, err := getDeltaRecord(t); err != nil {
+		return "", err
+	} else if rec != nil {
+		// Already stored under this sha, as a full blob or a delta -- nothing more to do.
+		return t, nil
+	}
+
+	parent, err := loadDatabase(parentSha)
+	if err != nil {
+		return storeDatabase(db)
+	}
+
+	p, err := buildDelta(parent, db)
+	if err != nil {
+		return storeDatabase(db)
+	}
+	p.ParentSha = parentSha
+
+	j, err := json.MarshalIndent(p, "", " ")
+	if err != nil {
+		log.Printf("Something went wrong when serialising the pack data: %v\n", err.Error())
+		return "", err
+	}
+	if err := store.Write(filepath.Join("files", "packs", t), j); err != nil {
+		return "", err
+	}
+	if err := setDeltaRecord(t, deltaRecord{Kind: "delta", Parent: parentSha}); err != nil {
+		return "", err
+	}
+	return t, nil
+}
+
+// previousDatabaseSha finds the database blob sha referenced by the most recently known commit
+// in index (its last entry, since getIndex returns commits oldest first), so a newly stored
+// database can be delta-encoded against it.  It returns "" if index is empty, meaning the new
+// blob has no parent to delta against.
+func previousDatabaseSha(index []commit) (string, error) {
+	if len(index) == 0 {
+		return "", nil
+	}
+
+	last := index[len(index)-1]
+	j, err := store.Read(filepath.Join("files", last.Tree))
+	if err != nil {
+		return "", err
+	}
+	var t dbTree
+	if err := json.Unmarshal(j, &t); err != nil {
+		return "", err
+	}
+	if len(t.Entries) == 0 {
+		return "", nil
+	}
+	return t.Entries[0].ShaSum, nil
+}
+
+// loadDatabase reconstructs the full database blob for sha, recursively resolving its parent
+// chain if sha was stored as a delta.
+func loadDatabase(sha string) ([]byte, error) {
+	rec, err := getDeltaRecord(sha)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Kind == "full" {
+		return readVerifiedBlob(filepath.Join("files", sha), sha)
+	}
+
+	parent, err := loadDatabase(rec.Parent)
+	if err != nil {
+		return nil, err
+	}
+	j, err := store.Read(filepath.Join("files", "packs", sha))
+	if err != nil {
+		return nil, err
+	}
+	var p pack
+	if err := json.Unmarshal(j, &p); err != nil {
+		return nil, err
+	}
+	return applyDelta(parent, &p)
+}
+
+// repackDeltaChains converts any database blob whose delta chain is deeper than maxDepth back
+// into a full blob, git-pack style.  It returns the number of blobs repacked.
+func repackDeltaChains(maxDepth int) (int, error) {
+	m, err := getDeltaMap()
+	if err != nil {
+		return 0, err
+	}
+
+	repacked := 0
+	for sha, rec := range m {
+		depth := 0
+		for r := rec; r.Kind == "delta"; depth++ {
+			next, ok := m[r.Parent]
+			if !ok || depth > maxDepth {
+				break
+			}
+			r = next
+		}
+		if depth <= maxDepth {
+			continue
+		}
+
+		db, err := loadDatabase(sha)
+		if err != nil {
+			return repacked, err
+		}
+		if err := store.Write(filepath.Join("files", sha), db); err != nil {
+			return repacked, err
+		}
+		if err := setDeltaRecord(sha, deltaRecord{Kind: "full"}); err != nil {
+			return repacked, err
+		}
+		repacked++
+	}
+	return repacked, nil
+}
+
+// getDeltaMap returns the full sha -> deltaRecord mapping stored in deltas.json.
+func getDeltaMap() (map[string]deltaRecord, error) {
+	m := make(map[string]deltaRecord)
+	exists, err := store.Exists("deltas.json")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return m, nil
+	}
+	b, err := store.Read("deltas.json")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		log.Printf("Something went wrong when unserialising the deltas data: %v\n", err.Error())
+		return nil, err
+	}
+	return m, nil
+}
+
+// saveDeltaMap rewrites deltas.json with m.
+func saveDeltaMap(m map[string]deltaRecord) error {
+	j, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		log.Printf("Something went wrong when serialising the deltas data: %v\n", err.Error())
+		return err
+	}
+	return store.Write("deltas.json", j)
+}
+
+// getDeltaRecord returns the deltaRecord for sha, or nil if none is recorded (eg it predates
+// delta storage, and should be treated as a full blob).
+func getDeltaRecord(sha string) (*deltaRecord, error) {
+	m, err := getDeltaMap()
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := m[sha]; ok {
+		return &r, nil
+	}
+	return nil, nil
+}
+
+// setDeltaRecord records r as sha's entry in deltas.json.
+func setDeltaRecord(sha string, r deltaRecord) error {
+	m, err := getDeltaMap()
+	if err != nil {
+		return err
+	}
+	m[sha] = r
+	return saveDeltaMap(m)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/justinclift/dio/cmd"
+	rq "github.com/parnurzeal/gorequest"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var pullCmdBranch, cloneCmdDir string
+
+// Downloads a database and its commit graph from a DBHub.io cloud.
+var pullCmd = &cobra.Command{
+	Use:   "pull [database name]",
+	Short: "Download a database",
+	RunE: func(c *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("No database name specified")
+		}
+		if len(args) > 1 {
+			return errors.New("Only one database can be pulled at a time (for now)")
+		}
+		return runPull(args[0], pullCmdBranch)
+	},
+}
+
+// Clones a database into a fresh local storage directory.
+var cloneCmd = &cobra.Command{
+	Use:   "clone [database name]",
+	Short: "Clone a database into a fresh local storage directory",
+	RunE: func(c *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("No database name specified")
+		}
+		if len(args) > 1 {
+			return errors.New("Only one database can be cloned at a time (for now)")
+		}
+
+		if cmd.StorageURL != "" {
+			// A remote backend was explicitly requested via --storage; honour it as-is rather
+			// than forcing a local directory underneath it.
+			return runPull(args[0], pullCmdBranch)
+		}
+
+		dir := cloneCmdDir
+		if dir == "" {
+			dir = args[0]
+		}
+
+		// Blob storage is pluggable via the Storage interface, but the SQLite metadata store
+		// (metadata.go) still reads STORAGEDIR directly -- relocate it too, and drop the cached
+		// connection, so the clone's commits/branches land in dir's own dio.db rather than
+		// whichever one every other local repo already shares.
+		STORAGEDIR = dir
+		metaDB = nil
+
+		if err := InitStorage("file://" + dir); err != nil {
+			return err
+		}
+		return runPull(args[0], pullCmdBranch)
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(pullCmd)
+	cmd.RootCmd.AddCommand(cloneCmd)
+	pullCmd.Flags().StringVar(&pullCmdBranch, "branch", "master", "Remote branch to pull")
+	cloneCmd.Flags().StringVar(&pullCmdBranch, "branch", "master", "Remote branch to clone")
+	cloneCmd.Flags().StringVar(&cloneCmdDir, "dir", "", "Directory to clone into (defaults to the database name)")
+}
+
+// runPull fetches the database blob at dbName's branch head, plus any commits and trees local
+// storage doesn't already have, from the configured cloud, then updates the local index and
+// branches.  The database blobs of older commits are not downloaded.
+func runPull(dbName, branchName string) error {
+	existing, err := getIndex(dbName)
+	if err != nil {
+		return err
+	}
+	have := make([]string, len(existing))
+	for i, c := range existing {
+		have[i] = c.ID
+	}
+
+	req := rq.New().Get(cloud+"/db_download").
+		Set("database", dbName).
+		Set("branch", branchName)
+	for _, id := range have {
+		req.Query("have=" + id)
+	}
+	resp, body, errs := req.End()
+	if errs != nil {
+		return fmt.Errorf("error downloading database: %v", errs[0])
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("database download failed with HTTP status %d - '%v'", resp.StatusCode, resp.Status)
+	}
+	parentSha, err := previousDatabaseSha(existing)
+	if err != nil {
+		return err
+	}
+	if _, err := storeDatabaseWithParent([]byte(body), parentSha); err != nil {
+		return err
+	}
+
+	if err := pullCommitGraph(dbName, branchName, existing, have); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s - Database download successful.  Name: %s, branch: %s\n", cloud, dbName, branchName)
+	return nil
+}
+
+// pullCommitGraph walks the remote commit graph for branchName starting at its head, fetching
+// each commit and tree the caller doesn't already have, storing them locally, recording the
+// newly fetched commits in the local index alongside existing, and finally updating branchName
+// in the local branches (leaving every other locally-tracked branch for dbName untouched).
+func pullCommitGraph(dbName, branchName string, existing []commit, have []string) error {
+	resp, body, errs := rq.New().Get(cloud+"/commit").
+		Set("database", dbName).
+		Set("branch", branchName).
+		End()
+	if errs != nil {
+		return fmt.Errorf("error retrieving remote commit graph: %v", errs[0])
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("commit graph retrieval failed with HTTP status %d - '%v'", resp.StatusCode, resp.Status)
+	}
+
+	var remoteCommits []commit
+	if err := json.Unmarshal([]byte(body), &remoteCommits); err != nil {
+		return err
+	}
+
+	haveSet := make(map[string]bool, len(have))
+	for _, id := range have {
+		haveSet[id] = true
+	}
+
+	index := existing
+	var headID string
+	for i, c := range remoteCommits {
+		if i == 0 {
+			headID = c.ID
+		}
+		if haveSet[c.ID] {
+			continue
+		}
+		if err := storeCommit(c); err != nil {
+			return err
+		}
+		if err := pullTree(dbName, c.Tree); err != nil {
+			return err
+		}
+		index = append(index, c)
+	}
+
+	if err := storeIndex(dbName, index); err != nil {
+		return err
+	}
+
+	return upsertBranch(dbName, branchName, headID)
+}
+
+// upsertBranch updates dbName's branch set so branchName points at commitID, leaving every
+// other locally-tracked branch for dbName untouched.
+func upsertBranch(dbName, branchName, commitID string) error {
+	branches, err := getBranches(dbName)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, b := range branches {
+		if b.Name == branchName {
+			branches[i].Commit = commitID
+			found = true
+			break
+		}
+	}
+	if !found {
+		branches = append(branches, branch{Name: branchName, Commit: commitID})
+	}
+
+	return storeBranches(dbName, branches)
+}
+
+// pullTree fetches a tree object (and stores it locally) if it isn't already present.
+func pullTree(dbName, treeID string) error {
+	exists, err := store.Exists(filepath.Join("files", treeID))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	resp, body, errs := rq.New().Get(cloud+"/tree").
+		Set("database", dbName).
+		Set("id", treeID).
+		End()
+	if errs != nil {
+		return fmt.Errorf("error retrieving tree '%s': %v", treeID, errs[0])
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tree retrieval failed with HTTP status %d - '%v'", resp.StatusCode, resp.Status)
+	}
+
+	var t dbTree
+	if err := json.Unmarshal([]byte(body), &t); err != nil {
+		return err
+	}
+	return storeTree(t)
+}
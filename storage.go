@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/justinclift/dio/cmd"
+	"github.com/spf13/cobra"
+)
+
+// Storage is the interface implemented by each supported blob storage backend.  It lets the
+// store* functions read and write content-addressed dio objects (commits, trees, database
+// blobs) without caring whether the bytes end up on local disk, in S3, or in Google Cloud
+// Storage.
+type Storage interface {
+	// Write stores content at path, creating any parent directories/prefixes needed along the way.
+	Write(path string, content []byte) error
+
+	// Read returns the content stored at path.
+	Read(path string) ([]byte, error)
+
+	// Exists reports whether path is already present in the backend.
+	Exists(path string) (bool, error)
+
+	// List returns the paths of every object stored under prefix.
+	List(prefix string) ([]string, error)
+}
+
+// store is the configured backend used by all the store* functions (see util.go).  It defaults to local
+// storage rooted at STORAGEDIR, and can be overridden at startup by calling InitStorage().
+var store Storage = NewLocalStorage(STORAGEDIR)
+
+func init() {
+	// Select the backend from --storage once flags have been parsed, before any command's RunE
+	// runs, so push/pull/log/fsck/gc all honour it (not just clone, which calls InitStorage
+	// explicitly with its own fresh-directory URL).
+	cobra.OnInitialize(func() {
+		if err := InitStorage(cmd.StorageURL); err != nil {
+			log.Fatalf("couldn't initialize storage backend: %v", err)
+		}
+	})
+}
+
+// InitStorage configures the package-level storage backend from a --storage URL, eg
+// "file:///var/lib/dio", "s3://my-bucket/dio", or "gs://my-bucket/dio".  It should be called
+// once at startup, before any store* functions are used.  An empty rawURL leaves the default
+// local filesystem backend in place.
+func InitStorage(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+	s, err := NewStorage(rawURL)
+	if err != nil {
+		return err
+	}
+	store = s
+	return nil
+}
+
+// NewStorage parses rawURL and returns the Storage backend it describes.  Supported schemes are
+// "file" (or no scheme, for a plain path), "s3", and "gs".
+func NewStorage(rawURL string) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse storage URL '%s': %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		// A relative path given as "file://mydir" (rather than the absolute "file:///mydir") is
+		// parsed by net/url with "mydir" landing in Host, not Path -- fold it back in so the two
+		// forms behave the same.
+		p := u.Path
+		if u.Host != "" {
+			p = filepath.Join(u.Host, p)
+		}
+		if p == "" {
+			p = u.Opaque
+		}
+		return NewLocalStorage(p), nil
+	case "s3":
+		return NewS3Storage(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		return NewGCSStorage(u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	return nil, fmt.Errorf("unknown storage scheme '%s' in URL '%s'", u.Scheme, rawURL)
+}
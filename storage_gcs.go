@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSStorage implements Storage by reading and writing objects in a Google Cloud Storage
+// bucket, under an optional object name prefix.
+type GCSStorage struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// NewGCSStorage returns a GCSStorage backend for bucket, storing objects under prefix.  It uses
+// Application Default Credentials to authenticate.
+func NewGCSStorage(bucket, prefix string) (*GCSStorage, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create Google Cloud Storage client: %v", err)
+	}
+	return &GCSStorage{bucket: client.Bucket(bucket), prefix: prefix}, nil
+}
+
+func (g *GCSStorage) object(path string) string {
+	if g.prefix == "" {
+		return path
+	}
+	return g.prefix + "/" + path
+}
+
+func (g *GCSStorage) Write(path string, content []byte) error {
+	ctx := context.Background()
+	w := g.bucket.Object(g.object(path)).NewWriter(ctx)
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return fmt.Errorf("couldn't write '%s' to Google Cloud Storage: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("couldn't write '%s' to Google Cloud Storage: %v", path, err)
+	}
+	return nil
+}
+
+func (g *GCSStorage) Read(path string) ([]byte, error) {
+	ctx := context.Background()
+	r, err := g.bucket.Object(g.object(path)).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s' from Google Cloud Storage: %v", path, err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s' from Google Cloud Storage: %v", path, err)
+	}
+	return b, nil
+}
+
+func (g *GCSStorage) Exists(path string) (bool, error) {
+	ctx := context.Background()
+	_, err := g.bucket.Object(g.object(path)).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("couldn't check existence of '%s' in Google Cloud Storage: %v", path, err)
+	}
+	return true, nil
+}
+
+func (g *GCSStorage) List(prefix string) ([]string, error) {
+	ctx := context.Background()
+	it := g.bucket.Objects(ctx, &storage.Query{Prefix: g.object(prefix)})
+
+	var paths []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list '%s' in Google Cloud Storage: %v", prefix, err)
+		}
+		name := attrs.Name
+		if g.prefix != "" {
+			name = strings.TrimPrefix(name, g.prefix+"/")
+		}
+		paths = append(paths, name)
+	}
+	return paths, nil
+}
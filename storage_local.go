@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LocalStorage implements Storage by reading and writing files under a base directory on the
+// local filesystem.  It's the default backend.  When a remote backend (S3, GCS) is configured it
+// is replaced entirely, and objects are not cached locally.
+type LocalStorage struct {
+	baseDir string
+}
+
+// NewLocalStorage returns a LocalStorage rooted at baseDir.
+func NewLocalStorage(baseDir string) *LocalStorage {
+	return &LocalStorage{baseDir: baseDir}
+}
+
+func (l *LocalStorage) Write(path string, content []byte) error {
+	p := filepath.Join(l.baseDir, path)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|0755); err != nil {
+		return fmt.Errorf("couldn't create storage directory for '%s': %v", path, err)
+	}
+	if err := ioutil.WriteFile(p, content, os.ModePerm); err != nil {
+		return fmt.Errorf("couldn't write '%s' to local storage: %v", path, err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) Read(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(l.baseDir, path))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s' from local storage: %v", path, err)
+	}
+	return b, nil
+}
+
+func (l *LocalStorage) Exists(path string) (bool, error) {
+	_, err := os.Stat(filepath.Join(l.baseDir, path))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("couldn't check existence of '%s' in local storage: %v", path, err)
+}
+
+func (l *LocalStorage) List(prefix string) ([]string, error) {
+	base := filepath.Join(l.baseDir, prefix)
+	var paths []string
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.baseDir, p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list '%s' in local storage: %v", prefix, err)
+	}
+	return paths, nil
+}
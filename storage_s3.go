@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Storage implements Storage by reading and writing objects in an AWS S3 bucket, under an
+// optional key prefix.
+type S3Storage struct {
+	bucket string
+	prefix string
+	svc    *s3.S3
+}
+
+// NewS3Storage returns an S3Storage backend for bucket, storing objects under prefix.  It uses
+// the standard AWS SDK credential chain (env vars, shared config, instance role, etc).
+func NewS3Storage(bucket, prefix string) (*S3Storage, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create AWS session: %v", err)
+	}
+	return &S3Storage{bucket: bucket, prefix: prefix, svc: s3.New(sess)}, nil
+}
+
+func (s *S3Storage) key(path string) string {
+	if s.prefix == "" {
+		return path
+	}
+	return s.prefix + "/" + path
+}
+
+func (s *S3Storage) Write(path string, content []byte) error {
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(path)),
+		Body:   bytes.NewReader(content),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write '%s' to S3: %v", path, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Read(path string) ([]byte, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(path)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s' from S3: %v", path, err)
+	}
+	defer out.Body.Close()
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read '%s' from S3: %v", path, err)
+	}
+	return b, nil
+}
+
+func (s *S3Storage) Exists(path string) (bool, error) {
+	_, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(path)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return false, nil
+		}
+		return false, fmt.Errorf("couldn't check existence of '%s' in S3: %v", path, err)
+	}
+	return true, nil
+}
+
+func (s *S3Storage) List(prefix string) ([]string, error) {
+	var paths []string
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key(prefix)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			paths = append(paths, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list '%s' in S3: %v", prefix, err)
+	}
+	return paths, nil
+}